@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dra implements the kubelet Dynamic Resource Allocation plugin
+// interface (resource.k8s.io) as an alternative to the
+// k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1 driver implemented by
+// NvidiaDevicePlugin. Where the device plugin API only lets a pod request
+// an opaque integer count of a named resource, DRA claims carry structured
+// parameters (minimum memory, compute capability, MIG profile), which this
+// driver resolves against the same GPU/MIG inventory the device plugin
+// uses.
+//
+// Selecting between the two is a top-level --driver-mode flag; this
+// package only needs a ResourceManager to be wired in.
+package dra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+	drapbv1alpha3 "k8s.io/kubelet/pkg/apis/dra/v1alpha3"
+)
+
+// Device is the subset of device metadata the DRA driver needs to honor a
+// claim and build its CDI spec.
+type Device struct {
+	UUID              string
+	Path              string
+	MemoryMB          uint64
+	ComputeCapability string
+	MigProfile        string
+}
+
+// ResourceManager supplies the GPU/MIG inventory to allocate from. It is
+// implemented by the plugin's GpuDeviceManager/MigDeviceManager so
+// discovery code is never duplicated between driver modes.
+type ResourceManager interface {
+	Devices() ([]Device, error)
+}
+
+// ClaimParameters are the structured parameters a claim.spec.parameters
+// resolves to. MemoryMinMB is a minimum; ComputeCapability and MigProfile,
+// if set, restrict allocation to devices with exactly that value.
+type ClaimParameters struct {
+	MemoryMinMB       uint64 `json:"memoryMinMB,omitempty"`
+	ComputeCapability string `json:"computeCapability,omitempty"`
+	MigProfile        string `json:"migProfile,omitempty"`
+}
+
+// Driver implements the kubelet DRA node plugin gRPC service.
+type Driver struct {
+	drapbv1alpha3.UnimplementedNodeServer
+
+	driverName string
+	socketPath string
+	rm         ResourceManager
+
+	mu      sync.Mutex
+	claimed map[string][]Device // claim UID -> devices prepared for it
+}
+
+// NewDriver creates a DRA node driver that allocates from rm and serves its
+// DRA Node gRPC service at socketPath.
+func NewDriver(driverName, socketPath string, rm ResourceManager) *Driver {
+	return &Driver{
+		driverName: driverName,
+		socketPath: socketPath,
+		rm:         rm,
+		claimed:    make(map[string][]Device),
+	}
+}
+
+// Run starts the gRPC server and blocks until ctx is cancelled.
+func (d *Driver) Run(ctx context.Context) error {
+	if err := os.RemoveAll(d.socketPath); err != nil {
+		return fmt.Errorf("unable to clear stale socket %s: %v", d.socketPath, err)
+	}
+
+	lis, err := net.Listen("unix", d.socketPath)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", d.socketPath, err)
+	}
+
+	server := grpc.NewServer()
+	drapbv1alpha3.RegisterNodeServer(server, d)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(lis)
+	}()
+
+	select {
+	case <-ctx.Done():
+		server.GracefulStop()
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// NodePrepareResources resolves each requested claim's structured
+// parameters against the current device inventory and returns the
+// NVIDIA_VISIBLE_DEVICES / CDI device names kubelet should make available
+// to the claiming pod's containers.
+func (d *Driver) NodePrepareResources(ctx context.Context, req *drapbv1alpha3.NodePrepareResourcesRequest) (*drapbv1alpha3.NodePrepareResourcesResponse, error) {
+	resp := &drapbv1alpha3.NodePrepareResourcesResponse{
+		Claims: make(map[string]*drapbv1alpha3.NodePrepareResourceResponse),
+	}
+
+	devices, err := d.rm.Devices()
+	if err != nil {
+		return nil, fmt.Errorf("unable to enumerate devices: %v", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, claim := range req.Claims {
+		if prepared, ok := d.claimed[claim.Uid]; ok {
+			resp.Claims[claim.Uid] = prepareResponseFor(prepared)
+			continue
+		}
+
+		params, err := parseClaimParameters(claim.ResourceHandle)
+		if err != nil {
+			resp.Claims[claim.Uid] = &drapbv1alpha3.NodePrepareResourceResponse{Error: err.Error()}
+			continue
+		}
+
+		matched := selectDevice(devices, params, d.allocatedUUIDs())
+		if matched == nil {
+			resp.Claims[claim.Uid] = &drapbv1alpha3.NodePrepareResourceResponse{
+				Error: fmt.Sprintf("no device satisfies claim %s (min memory %dMB, compute capability %q, mig profile %q)",
+					claim.Uid, params.MemoryMinMB, params.ComputeCapability, params.MigProfile),
+			}
+			continue
+		}
+
+		d.claimed[claim.Uid] = []Device{*matched}
+		resp.Claims[claim.Uid] = prepareResponseFor([]Device{*matched})
+	}
+
+	return resp, nil
+}
+
+// NodeUnprepareResources releases the devices previously prepared for each
+// named claim so they can be allocated again.
+func (d *Driver) NodeUnprepareResources(ctx context.Context, req *drapbv1alpha3.NodeUnprepareResourcesRequest) (*drapbv1alpha3.NodeUnprepareResourcesResponse, error) {
+	resp := &drapbv1alpha3.NodeUnprepareResourcesResponse{
+		Claims: make(map[string]*drapbv1alpha3.NodeUnprepareResourceResponse),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, claim := range req.Claims {
+		delete(d.claimed, claim.Uid)
+		resp.Claims[claim.Uid] = &drapbv1alpha3.NodeUnprepareResourceResponse{}
+	}
+
+	return resp, nil
+}
+
+func prepareResponseFor(devices []Device) *drapbv1alpha3.NodePrepareResourceResponse {
+	var cdiDevices []string
+	for _, dev := range devices {
+		cdiDevices = append(cdiDevices, fmt.Sprintf("nvidia.com/gpu=%s", dev.UUID))
+	}
+	return &drapbv1alpha3.NodePrepareResourceResponse{CDIDevices: cdiDevices}
+}
+
+// allocatedUUIDs returns the set of device UUIDs already prepared for some
+// other claim, so NodePrepareResources never double-books a device between
+// two concurrent claims. Callers must hold d.mu.
+func (d *Driver) allocatedUUIDs() map[string]struct{} {
+	allocated := make(map[string]struct{})
+	for _, devices := range d.claimed {
+		for _, dev := range devices {
+			allocated[dev.UUID] = struct{}{}
+		}
+	}
+	return allocated
+}
+
+func selectDevice(devices []Device, params ClaimParameters, excluded map[string]struct{}) *Device {
+	for i := range devices {
+		dev := &devices[i]
+		if _, taken := excluded[dev.UUID]; taken {
+			continue
+		}
+		if params.MigProfile != "" && dev.MigProfile != params.MigProfile {
+			continue
+		}
+		if dev.MemoryMB < params.MemoryMinMB {
+			continue
+		}
+		if params.ComputeCapability != "" && dev.ComputeCapability != params.ComputeCapability {
+			continue
+		}
+		return dev
+	}
+	return nil
+}
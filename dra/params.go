@@ -0,0 +1,39 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dra
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// parseClaimParameters decodes the opaque ResourceHandle the controller
+// wrote for this claim back into the structured parameters the user asked
+// for. The controller is expected to have copied the claim's
+// parameters.spec verbatim into the handle as JSON.
+func parseClaimParameters(resourceHandle string) (ClaimParameters, error) {
+	var params ClaimParameters
+	if resourceHandle == "" {
+		return params, nil
+	}
+
+	if err := json.Unmarshal([]byte(resourceHandle), &params); err != nil {
+		return ClaimParameters{}, fmt.Errorf("unable to parse claim parameters: %v", err)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/qingshanyinyin/k8s-device-plugin/dra"
+)
+
+// DriverMode selects which kubelet-facing API the plugin serves resources
+// through. It is exposed as the --driver-mode CLI flag (main.go, outside
+// this package, is expected to parse it and call NewDraDriver/the existing
+// NvidiaDevicePlugin constructors accordingly).
+const (
+	DriverModeDevicePlugin = "device-plugin"
+	DriverModeDRA          = "dra"
+)
+
+func ValidateDriverMode(mode string) error {
+	switch mode {
+	case DriverModeDevicePlugin, DriverModeDRA:
+		return nil
+	}
+	return fmt.Errorf("unknown --driver-mode: %v", mode)
+}
+
+// draResourceManager adapts our ResourceManager (GpuDeviceManager or
+// MigDeviceManager) to dra.ResourceManager, translating *Device into the
+// dra package's own Device type so it stays free of a pluginapi
+// dependency.
+type draResourceManager struct {
+	rm ResourceManager
+}
+
+func (m draResourceManager) Devices() ([]dra.Device, error) {
+	devices, err := m.rm.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []dra.Device
+	for _, d := range devices {
+		draDev := dra.Device{
+			UUID: d.UUID,
+			Path: d.Path,
+		}
+
+		if d.nvmlDevice != nil {
+			if mem, err := d.nvmlDevice.MemoryMB(); err == nil {
+				draDev.MemoryMB = mem
+			}
+			if cc, err := d.nvmlDevice.ComputeCapability(); err == nil {
+				draDev.ComputeCapability = cc
+			}
+			if profile, err := d.nvmlDevice.MigProfile(); err == nil {
+				draDev.MigProfile = profile
+			}
+		}
+
+		out = append(out, draDev)
+	}
+	return out, nil
+}
+
+// NewDraDriver builds the DRA node driver for the given ResourceManager,
+// reusing the same GpuDeviceManager/MigDeviceManager discovery code the
+// device-plugin driver mode uses.
+func NewDraDriver(driverName, socketPath string, rm ResourceManager) *dra.Driver {
+	return dra.NewDriver(driverName, socketPath, draResourceManager{rm: rm})
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// envDisableMetrics lets users who already scrape via dcgm-exporter
+	// turn this exporter off, mirroring envDisableHealthChecks.
+	envDisableMetrics = "DP_DISABLE_METRICS"
+	envMetricsPort    = "DP_METRICS_PORT"
+
+	defaultMetricsPort = 9400
+
+	sampleInterval = 30 * time.Second
+)
+
+func migLabels(gi, ci *uint) (string, string) {
+	if gi == nil || ci == nil {
+		return "", ""
+	}
+	return strconv.FormatUint(uint64(*gi), 10), strconv.FormatUint(uint64(*ci), 10)
+}
+
+var (
+	xidErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nvidia_gpu_xid_errors_total",
+		Help: "Total number of Xid critical errors received per device.",
+	}, []string{"uuid", "gi", "ci", "xid"})
+
+	deviceHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_healthy",
+		Help: "Whether the device is currently healthy (1) or not (0).",
+	}, []string{"uuid"})
+
+	memoryUsedBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_memory_used_bytes",
+		Help: "Memory used on the device, in bytes.",
+	}, []string{"uuid"})
+
+	memoryFreeBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_memory_free_bytes",
+		Help: "Memory free on the device, in bytes.",
+	}, []string{"uuid"})
+
+	smUtilPercent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_sm_utilization_percent",
+		Help: "SM utilization on the device, in percent.",
+	}, []string{"uuid"})
+
+	powerWatts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_power_watts",
+		Help: "Power draw of the device, in watts.",
+	}, []string{"uuid"})
+
+	temperatureCelsius = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_gpu_temperature_celsius",
+		Help: "Temperature of the device, in degrees Celsius.",
+	}, []string{"uuid"})
+)
+
+// recordXidEvent increments the Xid error counter for every event
+// received off the NVML event set, regardless of whether checkHealth goes
+// on to treat it as an application error or a device-down event.
+func recordXidEvent(e nvmlEvent) {
+	uuid := "all"
+	if e.UUID != nil {
+		uuid = *e.UUID
+	}
+	gi, ci := migLabels(e.GpuInstanceId, e.ComputeInstanceId)
+
+	xidErrorsTotal.WithLabelValues(uuid, gi, ci, strconv.FormatUint(e.Edata, 10)).Inc()
+}
+
+func setDeviceHealthy(uuid string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1.0
+	}
+	deviceHealthy.WithLabelValues(uuid).Set(v)
+}
+
+// StartMetricsServer serves the Prometheus /metrics endpoint on
+// DP_METRICS_PORT (default 9400) until ctx is cancelled. It is a no-op when
+// DP_DISABLE_METRICS is set, for users who already scrape GPU metrics via
+// dcgm-exporter.
+func StartMetricsServer(ctx context.Context) error {
+	if os.Getenv(envDisableMetrics) != "" {
+		return nil
+	}
+
+	port := defaultMetricsPort
+	if v := os.Getenv(envMetricsPort); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s=%q: %v", envMetricsPort, v, err)
+		}
+		port = p
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Warning: metrics server stopped: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// SampleDeviceMetrics periodically reads utilization/memory/power/
+// temperature for each device, using the same NVML handles the
+// ResourceManager already opened, until stop is closed.
+func SampleDeviceMetrics(stop <-chan interface{}, devices []*Device) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, d := range devices {
+			if d.nvmlDevice == nil {
+				continue
+			}
+			s := d.nvmlDevice.Sample()
+			memoryUsedBytes.WithLabelValues(d.ID).Set(float64(s.MemoryUsedBytes))
+			memoryFreeBytes.WithLabelValues(d.ID).Set(float64(s.MemoryFreeBytes))
+			smUtilPercent.WithLabelValues(d.ID).Set(float64(s.SMUtilPercent))
+			powerWatts.WithLabelValues(d.ID).Set(float64(s.PowerMilliWatts) / 1000)
+			temperatureCelsius.WithLabelValues(d.ID).Set(float64(s.TemperatureC))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/qingshanyinyin/k8s-device-plugin/migconfig"
+)
+
+const envMigConfigFile = "MIG_CONFIG_FILE"
+
+// migDevice adapts our NVML *device wrapper to migconfig.Device so the
+// migconfig package never needs to know about NVML directly.
+type migDevice struct {
+	*device
+}
+
+func (d migDevice) CurrentGeometry() ([]string, error) {
+	migs, err := d.GetMigDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []string
+	for _, mig := range migs {
+		attr, err := mig.GetAttributes()
+		if err != nil {
+			return nil, err
+		}
+		gb := (attr.MemorySizeMB + 1000 - 1) / 1000
+		profiles = append(profiles, fmt.Sprintf("%dg.%dgb", attr.GpuInstanceSliceCount, gb))
+	}
+	return profiles, nil
+}
+
+func (d migDevice) HasActiveWorkloads() (bool, error) {
+	return d.device.HasActiveComputeInstances()
+}
+
+func (d migDevice) DestroyAllInstances() error {
+	return d.device.DestroyAllGpuInstances()
+}
+
+func (d migDevice) CreateGpuInstance(profile string) error {
+	return d.device.CreateGpuInstanceForProfile(profile)
+}
+
+// ReconfigureMigGeometry applies the node's migconfig, if MIG_CONFIG_MODE is
+// "auto", before the plugin's MigStrategy is constructed. It is a no-op
+// when the mode is "off" (the default) or when no selector in the config
+// matches nodeLabels. In "auto" mode it returns an error if MIG_CONFIG_FILE
+// is unset or the file cannot be read.
+func ReconfigureMigGeometry(nodeLabels map[string]string) error {
+	mode := os.Getenv(migconfig.EnvConfigMode)
+	if mode == "" || mode == migconfig.ConfigModeOff {
+		return nil
+	}
+	if mode != migconfig.ConfigModeAuto {
+		return fmt.Errorf("unknown %s=%q, expected %q or %q", migconfig.EnvConfigMode, mode, migconfig.ConfigModeAuto, migconfig.ConfigModeOff)
+	}
+
+	path := os.Getenv(envMigConfigFile)
+	if path == "" {
+		return fmt.Errorf("%s=%s requires %s to be set", migconfig.EnvConfigMode, mode, envMigConfigFile)
+	}
+
+	cfg, err := migconfig.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	selector := cfg.SelectorFor(nodeLabels)
+	if selector == nil {
+		return nil
+	}
+
+	n, err := nvmlGetDeviceCount()
+	if err != nil {
+		return err
+	}
+
+	for i := uint(0); i < n; i++ {
+		d, err := nvmlNewDeviceLite(i)
+		if err != nil {
+			return err
+		}
+
+		if err := migconfig.Reconcile(migDevice{d}, selector.Profiles); err != nil {
+			return fmt.Errorf("GPU %s: %w", d.UUID, err)
+		}
+	}
+
+	return nil
+}
@@ -19,7 +19,6 @@ package main
 import (
 	"fmt"
 
-	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
@@ -27,13 +26,14 @@ const (
 	MigStrategyDisabled = "disabled"
 	MigStrategyNone     = "none"
 	MigStrategySingle   = "single"
+	MigStrategyMixed    = "mixed"
 )
 
 type MigStrategyResourceSet map[string]struct{}
 
 type MigStrategy interface {
-	GetPlugins() []*NvidiaDevicePlugin
-	MatchesResource(mig *nvml.Device, resource string) bool
+	GetPlugins() ([]*NvidiaDevicePlugin, error)
+	MatchesResource(mig *device, resource string) bool
 }
 
 func NewMigStrategy(strategy string) (MigStrategy, error) {
@@ -44,6 +44,8 @@ func NewMigStrategy(strategy string) (MigStrategy, error) {
 		return &migStrategyNone{}, nil
 	case MigStrategySingle:
 		return &migStrategySingle{}, nil
+	case MigStrategyMixed:
+		return &migStrategyMixed{}, nil
 	}
 	return nil, fmt.Errorf("Unknown strategy: %v", strategy)
 }
@@ -51,15 +53,20 @@ func NewMigStrategy(strategy string) (MigStrategy, error) {
 type migStrategyDisabled struct{}
 type migStrategyNone struct{}
 type migStrategySingle struct{}
+type migStrategyMixed struct{}
 
 // getAllMigDevices() across all full GPUs
-func getAllMigDevices() []*nvml.Device {
-	n, err := nvml.GetDeviceCount()
+func getAllMigDevices() ([]*device, error) {
+	if !EnabledFromEnv() {
+		return nil, ErrDeviceEnumerationDisabled
+	}
+
+	n, err := nvmlGetDeviceCount()
 	check(err)
 
-	var migs []*nvml.Device
+	var migs []*device
 	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDeviceLite(i)
+		d, err := nvmlNewDeviceLite(i)
 		check(err)
 
 		migEnabled, err := d.IsMigEnabled()
@@ -75,45 +82,58 @@ func getAllMigDevices() []*nvml.Device {
 		migs = append(migs, devs...)
 	}
 
-	return migs
+	return migs, nil
 }
 
 // migStrategyDisabled
-func (s *migStrategyDisabled) GetPlugins() []*NvidiaDevicePlugin {
+func (s *migStrategyDisabled) GetPlugins() ([]*NvidiaDevicePlugin, error) {
+	if !EnabledFromEnv() {
+		return nil, ErrDeviceEnumerationDisabled
+	}
+
 	return []*NvidiaDevicePlugin{
 		NewNvidiaDevicePlugin(
 			"nvidia.com/gpu",
-			NewGpuDeviceManager(false), // Enumerate device even if MIG enabled
+			NewGpuDeviceManager(EnabledFromEnv(), false), // Enumerate device even if MIG enabled
 			"NVIDIA_VISIBLE_DEVICES",
 			pluginapi.DevicePluginPath+"nvidia-gpu.sock"),
-	}
+	}, nil
 }
 
-func (s *migStrategyDisabled) MatchesResource(mig *nvml.Device, resource string) bool {
+func (s *migStrategyDisabled) MatchesResource(mig *device, resource string) bool {
 	panic("Should never be called")
 	return false
 }
 
 // migStrategyNone
-func (s *migStrategyNone) GetPlugins() []*NvidiaDevicePlugin {
+func (s *migStrategyNone) GetPlugins() ([]*NvidiaDevicePlugin, error) {
+	if !EnabledFromEnv() {
+		return nil, ErrDeviceEnumerationDisabled
+	}
+
 	return []*NvidiaDevicePlugin{
 		NewNvidiaDevicePlugin(
 			"nvidia.com/gpu",
-			NewGpuDeviceManager(true), // Skip device if MIG enabled
+			NewGpuDeviceManager(EnabledFromEnv(), true), // Skip device if MIG enabled
 			"NVIDIA_VISIBLE_DEVICES",
 			pluginapi.DevicePluginPath+"nvidia-gpu.sock"),
-	}
+	}, nil
 }
 
-func (s *migStrategyNone) MatchesResource(mig *nvml.Device, resource string) bool {
+func (s *migStrategyNone) MatchesResource(mig *device, resource string) bool {
 	panic("Should never be called")
 	return false
 }
 
 // migStrategySingle
-func (s *migStrategySingle) GetPlugins() []*NvidiaDevicePlugin {
+func (s *migStrategySingle) GetPlugins() ([]*NvidiaDevicePlugin, error) {
+	migs, err := getAllMigDevices()
+	if err != nil {
+		return nil, err
+	}
+
 	resources := make(MigStrategyResourceSet)
-	for _, mig := range getAllMigDevices() {
+	for _, mig := range migs {
 		r := s.getResourceName(mig)
 		resources[r] = struct{}{}
 	}
@@ -125,13 +145,24 @@ func (s *migStrategySingle) GetPlugins() []*NvidiaDevicePlugin {
 	return []*NvidiaDevicePlugin{
 		NewNvidiaDevicePlugin(
 			"nvidia.com/gpu",
-			NewMigDeviceManager(s, "gpu"),
+			NewMigDeviceManager(EnabledFromEnv(), s, "gpu"),
 			"NVIDIA_VISIBLE_DEVICES",
 			pluginapi.DevicePluginPath+"nvidia-gpu.sock"),
-	}
+	}, nil
 }
 
-func (s *migStrategySingle) getResourceName(mig *nvml.Device) string {
+func (s *migStrategySingle) getResourceName(mig *device) string {
+	return getMigDeviceResourceName(mig)
+}
+
+func (s *migStrategySingle) MatchesResource(mig *device, resource string) bool {
+	return true
+}
+
+// getMigDeviceResourceName builds the "mig-<c>c.<g>g.<gb>gb" resource name
+// for a MIG device, shared by any strategy that needs to group devices by
+// their MIG profile.
+func getMigDeviceResourceName(mig *device) string {
 	attr, err := mig.GetAttributes()
 	check(err)
 
@@ -143,6 +174,35 @@ func (s *migStrategySingle) getResourceName(mig *nvml.Device) string {
 	return r
 }
 
-func (s *migStrategySingle) MatchesResource(mig *nvml.Device, resource string) bool {
-	return true
+// migStrategyMixed advertises one resource per distinct MIG profile found
+// on the node, so a single GPU can be partitioned into heterogeneous slices
+// (e.g. a 3g.20gb and two 1g.5gb instances) and have each size scheduled
+// under its own resource name.
+func (s *migStrategyMixed) GetPlugins() ([]*NvidiaDevicePlugin, error) {
+	migs, err := getAllMigDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(MigStrategyResourceSet)
+	for _, mig := range migs {
+		r := getMigDeviceResourceName(mig)
+		resources[r] = struct{}{}
+	}
+
+	var plugins []*NvidiaDevicePlugin
+	for r := range resources {
+		plugins = append(plugins,
+			NewNvidiaDevicePlugin(
+				"nvidia.com/"+r,
+				NewMigDeviceManager(EnabledFromEnv(), s, r),
+				"NVIDIA_VISIBLE_DEVICES",
+				pluginapi.DevicePluginPath+"nvidia-"+r+".sock"))
+	}
+
+	return plugins, nil
+}
+
+func (s *migStrategyMixed) MatchesResource(mig *device, resource string) bool {
+	return getMigDeviceResourceName(mig) == resource
 }
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package migconfig reshapes a node's GPUs into a declarative MIG geometry
+// before the device plugin starts advertising resources, similar in spirit
+// to NVIDIA's mig-parted. It is driven by a ConfigMap-mounted YAML file
+// mapping node-label selectors to the GPU-instance profiles each matching
+// GPU should be partitioned into.
+package migconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvConfigMode selects whether migconfig runs at plugin startup.
+const EnvConfigMode = "MIG_CONFIG_MODE"
+
+const (
+	// ConfigModeAuto reshapes MIG geometry to match Config before the
+	// plugin advertises resources.
+	ConfigModeAuto = "auto"
+	// ConfigModeOff leaves MIG geometry untouched.
+	ConfigModeOff = "off"
+)
+
+// Config is the top-level layout of the mounted YAML file: an ordered list
+// of node selectors, each naming the GPU-instance profiles every GPU on a
+// matching node should be split into.
+type Config struct {
+	Selectors []NodeSelector `yaml:"selectors"`
+}
+
+// NodeSelector matches a set of node labels to a desired MIG geometry.
+type NodeSelector struct {
+	// Labels must all be present and equal on the node for this selector
+	// to apply. The first matching selector in the list wins.
+	Labels map[string]string `yaml:"labels"`
+
+	// Profiles lists the GPU-instance profiles to create on every GPU of
+	// a matching node, e.g. "1g.5gb x7" or "3g.20gb x2".
+	Profiles []string `yaml:"profiles"`
+}
+
+// ParseConfig parses the YAML contents of a migconfig file.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse migconfig: %v", err)
+	}
+	return &c, nil
+}
+
+// LoadConfig reads and parses a migconfig file from disk.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migconfig %s: %v", path, err)
+	}
+	return ParseConfig(data)
+}
+
+// SelectorFor returns the first selector in the config whose labels are all
+// present and equal in nodeLabels, or nil if none match.
+func (c *Config) SelectorFor(nodeLabels map[string]string) *NodeSelector {
+	for i := range c.Selectors {
+		s := &c.Selectors[i]
+		if s.matches(nodeLabels) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *NodeSelector) matches(nodeLabels map[string]string) bool {
+	for k, v := range s.Labels {
+		if nodeLabels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migconfig
+
+import "testing"
+
+func TestNodeSelectorMatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		nodeLabels map[string]string
+		want       bool
+	}{
+		{
+			name:       "empty selector matches any node",
+			labels:     map[string]string{},
+			nodeLabels: map[string]string{"gpu": "a100"},
+			want:       true,
+		},
+		{
+			name:       "all labels present and equal",
+			labels:     map[string]string{"gpu": "a100", "zone": "us-east"},
+			nodeLabels: map[string]string{"gpu": "a100", "zone": "us-east", "extra": "ignored"},
+			want:       true,
+		},
+		{
+			name:       "one label missing",
+			labels:     map[string]string{"gpu": "a100", "zone": "us-east"},
+			nodeLabels: map[string]string{"gpu": "a100"},
+			want:       false,
+		},
+		{
+			name:       "one label mismatched",
+			labels:     map[string]string{"gpu": "a100"},
+			nodeLabels: map[string]string{"gpu": "h100"},
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NodeSelector{Labels: tt.labels}
+			if got := s.matches(tt.nodeLabels); got != tt.want {
+				t.Errorf("matches(%v) = %v, want %v", tt.nodeLabels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigSelectorFor(t *testing.T) {
+	cfg := &Config{
+		Selectors: []NodeSelector{
+			{Labels: map[string]string{"gpu": "a100"}, Profiles: []string{"1g.5gb x7"}},
+			{Labels: map[string]string{"gpu": "h100"}, Profiles: []string{"3g.40gb x2"}},
+			{Labels: map[string]string{}, Profiles: []string{"default"}},
+		},
+	}
+
+	t.Run("first matching selector wins", func(t *testing.T) {
+		got := cfg.SelectorFor(map[string]string{"gpu": "a100"})
+		if got == nil || got.Profiles[0] != "1g.5gb x7" {
+			t.Fatalf("SelectorFor(gpu=a100) = %v, want the a100 selector", got)
+		}
+	})
+
+	t.Run("falls through to catch-all selector", func(t *testing.T) {
+		got := cfg.SelectorFor(map[string]string{"gpu": "v100"})
+		if got == nil || got.Profiles[0] != "default" {
+			t.Fatalf("SelectorFor(gpu=v100) = %v, want the catch-all selector", got)
+		}
+	})
+
+	t.Run("no selectors defined", func(t *testing.T) {
+		empty := &Config{}
+		if got := empty.SelectorFor(map[string]string{"gpu": "a100"}); got != nil {
+			t.Fatalf("SelectorFor() = %v, want nil", got)
+		}
+	})
+}
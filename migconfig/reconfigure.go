@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migconfig
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrGPUBusy is returned by Reconcile when a GPU's MIG geometry needs to
+// change but the GPU has active workloads. Callers must treat this as a
+// clean failure: mark the device plugin disabled for this GPU rather than
+// panic, since tearing down instances underneath a running workload would
+// be destructive.
+var ErrGPUBusy = errors.New("GPU has active workloads, refusing to reconfigure MIG geometry")
+
+// Device is the subset of NVML GPU-instance management that Reconcile
+// needs. It is implemented by the plugin's NVML wrapper so this package
+// stays free of a direct NVML dependency.
+type Device interface {
+	// CurrentGeometry returns one profile name per GPU instance currently
+	// configured on the device, e.g. ["1g.5gb", "1g.5gb", "3g.20gb"].
+	CurrentGeometry() ([]string, error)
+
+	// HasActiveWorkloads reports whether any compute instance on the
+	// device currently has a process running on it.
+	HasActiveWorkloads() (bool, error)
+
+	// DestroyAllInstances destroys every compute and GPU instance on the
+	// device.
+	DestroyAllInstances() error
+
+	// CreateGpuInstance creates a single GPU instance (and its default
+	// compute instance) for the named profile, e.g. "1g.5gb".
+	CreateGpuInstance(profile string) error
+}
+
+// Reconcile drives a single GPU's MIG geometry towards the profiles named
+// in desired (e.g. []string{"1g.5gb x7"}). It is a no-op if the GPU's
+// current geometry already matches, and returns ErrGPUBusy rather than
+// mutating a GPU that has active workloads.
+func Reconcile(dev Device, desired []string) error {
+	wanted, err := expandProfiles(desired)
+	if err != nil {
+		return err
+	}
+
+	current, err := dev.CurrentGeometry()
+	if err != nil {
+		return fmt.Errorf("unable to read current MIG geometry: %v", err)
+	}
+
+	if geometryMatches(current, wanted) {
+		return nil
+	}
+
+	busy, err := dev.HasActiveWorkloads()
+	if err != nil {
+		return fmt.Errorf("unable to determine GPU workload state: %v", err)
+	}
+	if busy {
+		return ErrGPUBusy
+	}
+
+	if err := dev.DestroyAllInstances(); err != nil {
+		return fmt.Errorf("unable to destroy existing MIG instances: %v", err)
+	}
+
+	for _, profile := range wanted {
+		if err := dev.CreateGpuInstance(profile); err != nil {
+			return fmt.Errorf("unable to create GPU instance %s: %v", profile, err)
+		}
+	}
+
+	return nil
+}
+
+// expandProfiles turns specs like "1g.5gb x7" into a flat list containing
+// "1g.5gb" seven times. A spec with no " xN" suffix is taken to mean one
+// instance of that profile.
+func expandProfiles(specs []string) ([]string, error) {
+	var profiles []string
+	for _, spec := range specs {
+		profile, count, err := parseProfileSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+func parseProfileSpec(spec string) (string, int, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		return fields[0], 1, nil
+	case 2:
+		countStr := strings.TrimPrefix(fields[1], "x")
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < 1 {
+			return "", 0, fmt.Errorf("invalid profile count in %q", spec)
+		}
+		return fields[0], count, nil
+	default:
+		return "", 0, fmt.Errorf("invalid profile spec %q", spec)
+	}
+}
+
+// geometryMatches compares two profile lists ignoring order, so a GPU
+// whose instances were created in a different order than desired is still
+// considered up to date.
+func geometryMatches(current, desired []string) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+
+	a := append([]string(nil), current...)
+	b := append([]string(nil), desired...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfileSpec(t *testing.T) {
+	tests := []struct {
+		spec        string
+		profile     string
+		count       int
+		expectError bool
+	}{
+		{spec: "1g.5gb", profile: "1g.5gb", count: 1},
+		{spec: "1g.5gb x7", profile: "1g.5gb", count: 7},
+		{spec: "3g.20gb x1", profile: "3g.20gb", count: 1},
+		{spec: "1g.5gb x0", expectError: true},
+		{spec: "1g.5gb xnotanumber", expectError: true},
+		{spec: "1g.5gb x7 x8", expectError: true},
+		{spec: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			profile, count, err := parseProfileSpec(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("parseProfileSpec(%q) = (%q, %d, nil), want an error", tt.spec, profile, count)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseProfileSpec(%q) returned unexpected error: %v", tt.spec, err)
+			}
+			if profile != tt.profile || count != tt.count {
+				t.Errorf("parseProfileSpec(%q) = (%q, %d), want (%q, %d)", tt.spec, profile, count, tt.profile, tt.count)
+			}
+		})
+	}
+}
+
+func TestExpandProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		specs       []string
+		want        []string
+		expectError bool
+	}{
+		{
+			name:  "single instance specs",
+			specs: []string{"1g.5gb", "3g.20gb"},
+			want:  []string{"1g.5gb", "3g.20gb"},
+		},
+		{
+			name:  "repeated instance spec",
+			specs: []string{"1g.5gb x3"},
+			want:  []string{"1g.5gb", "1g.5gb", "1g.5gb"},
+		},
+		{
+			name:  "mixed specs",
+			specs: []string{"1g.5gb x2", "3g.20gb"},
+			want:  []string{"1g.5gb", "1g.5gb", "3g.20gb"},
+		},
+		{
+			name:        "invalid spec fails the whole expansion",
+			specs:       []string{"1g.5gb", "bogus xnotanumber"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandProfiles(tt.specs)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expandProfiles(%v) = (%v, nil), want an error", tt.specs, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandProfiles(%v) returned unexpected error: %v", tt.specs, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandProfiles(%v) = %v, want %v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeometryMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		desired []string
+		want    bool
+	}{
+		{
+			name:    "identical order",
+			current: []string{"1g.5gb", "1g.5gb", "3g.20gb"},
+			desired: []string{"1g.5gb", "1g.5gb", "3g.20gb"},
+			want:    true,
+		},
+		{
+			name:    "same multiset, different order",
+			current: []string{"3g.20gb", "1g.5gb", "1g.5gb"},
+			desired: []string{"1g.5gb", "1g.5gb", "3g.20gb"},
+			want:    true,
+		},
+		{
+			name:    "different counts",
+			current: []string{"1g.5gb"},
+			desired: []string{"1g.5gb", "1g.5gb"},
+			want:    false,
+		},
+		{
+			name:    "different profiles",
+			current: []string{"1g.5gb", "1g.5gb"},
+			desired: []string{"1g.5gb", "3g.20gb"},
+			want:    false,
+		},
+		{
+			name:    "both empty",
+			current: nil,
+			desired: nil,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := geometryMatches(tt.current, tt.desired); got != tt.want {
+				t.Errorf("geometryMatches(%v, %v) = %v, want %v", tt.current, tt.desired, got, tt.want)
+			}
+		})
+	}
+}
@@ -17,35 +17,82 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
-	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
-
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 const (
 	envDisableHealthChecks = "DP_DISABLE_HEALTHCHECKS"
 	allHealthChecks        = "xids"
+
+	// envEnabled lets an operator ship the plugin to every node (e.g. via a
+	// DaemonSet with no node selector) and have it no-op on hosts without a
+	// GPU, rather than node-selecting the DaemonSet itself.
+	envEnabled = "DP_ENABLED"
 )
 
+// ErrDeviceEnumerationDisabled is returned by ResourceManager.Devices() and
+// MigStrategy.GetPlugins() when the plugin has been disabled for this node,
+// so the caller can skip registration instead of treating the lack of an
+// NVML handle as fatal.
+var ErrDeviceEnumerationDisabled = errors.New("device enumeration disabled")
+
+// EnabledFromEnv reports whether the plugin is enabled on this node,
+// defaulting to true so existing deployments keep working unchanged. It
+// backs the DP_ENABLED environment variable; main.go (outside this chunk,
+// same as the --driver-mode flag in driver-mode.go) is expected to parse a
+// corresponding --enabled CLI flag and fall back to this function when the
+// flag isn't explicitly set.
+func EnabledFromEnv() bool {
+	v, ok := os.LookupEnv(envEnabled)
+	if !ok {
+		return true
+	}
+
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, defaulting to enabled", envEnabled, v)
+		return true
+	}
+
+	return enabled
+}
+
 type Device struct {
 	pluginapi.Device
 	Path string
+
+	// Metadata surfaced so downstream schedulers and monitoring stacks can
+	// correlate an allocation with the underlying hardware.
+	BusID             string
+	Serial            string
+	UUID              string
+	GpuInstanceID     *uint
+	ComputeInstanceID *uint
+
+	// nvmlDevice is the handle Devices() built this Device from. It is
+	// kept around so the metrics exporter can sample utilization/memory/
+	// power/temperature without re-enumerating NVML.
+	nvmlDevice *device
 }
 
 type ResourceManager interface {
-	Devices() []*Device
+	Devices() ([]*Device, error)
 	CheckHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *Device)
 }
 
 type GpuDeviceManager struct {
+	enabled            bool
 	skipMigEnabledGPUs bool
 }
 
 type MigDeviceManager struct {
+	enabled  bool
 	strategy MigStrategy
 	resource string
 }
@@ -56,26 +103,32 @@ func check(err error) {
 	}
 }
 
-func NewGpuDeviceManager(skipMigEnabledGPUs bool) *GpuDeviceManager {
+func NewGpuDeviceManager(enabled, skipMigEnabledGPUs bool) *GpuDeviceManager {
 	return &GpuDeviceManager{
+		enabled:            enabled,
 		skipMigEnabledGPUs: skipMigEnabledGPUs,
 	}
 }
 
-func NewMigDeviceManager(strategy MigStrategy, resource string) *MigDeviceManager {
+func NewMigDeviceManager(enabled bool, strategy MigStrategy, resource string) *MigDeviceManager {
 	return &MigDeviceManager{
+		enabled:  enabled,
 		strategy: strategy,
 		resource: resource,
 	}
 }
 
-func (g *GpuDeviceManager) Devices() []*Device {
-	n, err := nvml.GetDeviceCount()
+func (g *GpuDeviceManager) Devices() ([]*Device, error) {
+	if !g.enabled {
+		return nil, ErrDeviceEnumerationDisabled
+	}
+
+	n, err := nvmlGetDeviceCount()
 	check(err)
 
 	var devs []*Device
 	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDeviceLite(i)
+		d, err := nvmlNewDeviceLite(i)
 		check(err)
 
 		migEnabled, err := d.IsMigEnabled()
@@ -88,16 +141,20 @@ func (g *GpuDeviceManager) Devices() []*Device {
 		devs = append(devs, buildDevice(d))
 	}
 
-	return devs
+	return devs, nil
 }
 
-func (m *MigDeviceManager) Devices() []*Device {
-	n, err := nvml.GetDeviceCount()
+func (m *MigDeviceManager) Devices() ([]*Device, error) {
+	if !m.enabled {
+		return nil, ErrDeviceEnumerationDisabled
+	}
+
+	n, err := nvmlGetDeviceCount()
 	check(err)
 
 	var devs []*Device
 	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDeviceLite(i)
+		d, err := nvmlNewDeviceLite(i)
 		check(err)
 
 		migEnabled, err := d.IsMigEnabled()
@@ -118,7 +175,7 @@ func (m *MigDeviceManager) Devices() []*Device {
 		}
 	}
 
-	return devs
+	return devs, nil
 }
 
 func (g *GpuDeviceManager) CheckHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *Device) {
@@ -129,11 +186,17 @@ func (g *MigDeviceManager) CheckHealth(stop <-chan interface{}, devices []*Devic
 	checkHealth(stop, devices, unhealthy)
 }
 
-func buildDevice(d *nvml.Device) *Device {
+func buildDevice(d *device) *Device {
 	dev := Device{}
 	dev.ID = d.UUID
 	dev.Health = pluginapi.Healthy
 	dev.Path = d.Path
+	dev.BusID = d.BusID
+	dev.Serial = d.Serial
+	dev.UUID = d.UUID
+	dev.GpuInstanceID = d.GpuInstanceID
+	dev.ComputeInstanceID = d.ComputeInstanceID
+	dev.nvmlDevice = d
 	if d.CPUAffinity != nil {
 		dev.Topology = &pluginapi.TopologyInfo{
 			Nodes: []*pluginapi.NUMANode{
@@ -155,19 +218,22 @@ func checkHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *D
 		return
 	}
 
-	eventSet := nvml.NewEventSet()
-	defer nvml.DeleteEventSet(eventSet)
+	eventSet := nvmlNewEventSet()
+	defer nvmlDeleteEventSet(eventSet)
 
 	for _, d := range devices {
-		gpu, _, _, err := nvml.ParseMigDeviceUUID(d.ID)
+		setDeviceHealthy(d.ID, true)
+
+		gpu, _, _, err := nvmlParseMigDeviceUUID(d.ID)
 		if err != nil {
 			gpu = d.ID
 		}
 
-		err = nvml.RegisterEventForDevice(eventSet, nvml.XidCriticalError, gpu)
+		err = nvmlRegisterEventForDevice(eventSet, nvmlXidCriticalError, gpu)
 		if err != nil && strings.HasSuffix(err.Error(), "Not Supported") {
 			log.Printf("Warning: %s is too old to support healthchecking: %s. Marking it unhealthy.", d.ID, err)
 			unhealthy <- d
+			setDeviceHealthy(d.ID, false)
 			continue
 		}
 		check(err)
@@ -180,11 +246,13 @@ func checkHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *D
 		default:
 		}
 
-		e, err := nvml.WaitForEvent(eventSet, 5000)
-		if err != nil && e.Etype != nvml.XidCriticalError {
+		e, err := nvmlWaitForEvent(eventSet, 5000)
+		if err != nil && e.Etype != nvmlXidCriticalError {
 			continue
 		}
 
+		recordXidEvent(e)
+
 		// FIXME: formalize the full list and document it.
 		// http://docs.nvidia.com/deploy/xid-errors/index.html#topic_4
 		// Application errors: the GPU should still be healthy
@@ -197,12 +265,13 @@ func checkHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *D
 			log.Printf("XidCriticalError: Xid=%d, All devices will go unhealthy.", e.Edata)
 			for _, d := range devices {
 				unhealthy <- d
+				setDeviceHealthy(d.ID, false)
 			}
 			continue
 		}
 
 		for _, d := range devices {
-			gpu, gi, ci, err := nvml.ParseMigDeviceUUID(d.ID)
+			gpu, gi, ci, err := nvmlParseMigDeviceUUID(d.ID)
 			if err != nil {
 				gpu = d.ID
 				gi = 0xFFFFFFFF
@@ -212,6 +281,7 @@ func checkHealth(stop <-chan interface{}, devices []*Device, unhealthy chan<- *D
 			if gpu == *e.UUID && gi == *e.GpuInstanceId && ci == *e.ComputeInstanceId {
 				log.Printf("XidCriticalError: Xid=%d on Device=%s, the device will go unhealthy.", e.Edata, d.ID)
 				unhealthy <- d
+				setDeviceHealthy(d.ID, false)
 			}
 		}
 	}
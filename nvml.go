@@ -0,0 +1,458 @@
+/*
+ * Copyright (c) 2023, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// This file is a thin compatibility layer over
+// github.com/NVIDIA/go-nvml/pkg/nvml, the actively-maintained replacement
+// for the deprecated github.com/NVIDIA/gpu-monitoring-tools bindings. It
+// keeps the call sites in nvidia.go and mig-strategy.go close to their
+// original shape: a *device with pre-populated fields, and plain `error`
+// returns instead of nvml.Return codes.
+
+// device is our view of an NVML device handle, full GPU or MIG instance,
+// with the fields the rest of the plugin cares about resolved up front.
+type device struct {
+	handle nvml.Device
+
+	UUID              string
+	Path              string
+	BusID             string
+	Serial            string
+	CPUAffinity       *uint
+	GpuInstanceID     *uint
+	ComputeInstanceID *uint
+}
+
+// NvmlInit initializes the NVML library. It must be called once, before any
+// ResourceManager or MigStrategy is used. Callers should skip it entirely
+// when EnabledFromEnv() is false, rather than treat a missing driver as
+// fatal.
+func NvmlInit() error {
+	return checkRet(nvml.Init())
+}
+
+// NvmlShutdown releases the NVML library, mirroring NvmlInit.
+func NvmlShutdown() error {
+	return checkRet(nvml.Shutdown())
+}
+
+func checkRet(ret nvml.Return) error {
+	if ret == nvml.SUCCESS {
+		return nil
+	}
+	return errors.New(nvml.ErrorString(ret))
+}
+
+func nvmlGetDeviceCount() (uint, error) {
+	n, ret := nvml.DeviceGetCount()
+	if err := checkRet(ret); err != nil {
+		return 0, err
+	}
+	return uint(n), nil
+}
+
+func nvmlNewDeviceLite(i uint) (*device, error) {
+	h, ret := nvml.DeviceGetHandleByIndex(int(i))
+	if err := checkRet(ret); err != nil {
+		return nil, err
+	}
+	return buildDeviceFromHandle(h)
+}
+
+func buildDeviceFromHandle(h nvml.Device) (*device, error) {
+	uuid, ret := h.GetUUID()
+	if err := checkRet(ret); err != nil {
+		return nil, err
+	}
+
+	minor, ret := h.GetMinorNumber()
+	if err := checkRet(ret); err != nil {
+		return nil, err
+	}
+
+	d := &device{
+		handle: h,
+		UUID:   uuid,
+		Path:   fmt.Sprintf("/dev/nvidia%d", minor),
+	}
+
+	if pci, ret := h.GetPciInfo(); ret == nvml.SUCCESS {
+		d.BusID = pciBusIDToString(pci.BusId)
+	}
+
+	if serial, ret := h.GetSerial(); ret == nvml.SUCCESS {
+		d.Serial = serial
+	}
+
+	if numa, ret := h.GetNumaNodeId(); ret == nvml.SUCCESS {
+		n := uint(numa)
+		d.CPUAffinity = &n
+	}
+
+	if gi, ret := h.GetGpuInstanceId(); ret == nvml.SUCCESS {
+		n := uint(gi)
+		d.GpuInstanceID = &n
+	}
+
+	if ci, ret := h.GetComputeInstanceId(); ret == nvml.SUCCESS {
+		n := uint(ci)
+		d.ComputeInstanceID = &n
+	}
+
+	return d, nil
+}
+
+func (d *device) IsMigEnabled() (bool, error) {
+	current, _, ret := d.handle.GetMigMode()
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		return false, nil
+	}
+	if err := checkRet(ret); err != nil {
+		return false, err
+	}
+	return current == nvml.DEVICE_MIG_ENABLE, nil
+}
+
+func (d *device) GetMigDevices() ([]*device, error) {
+	max, ret := d.handle.GetMaxMigDeviceCount()
+	if err := checkRet(ret); err != nil {
+		return nil, err
+	}
+
+	var migs []*device
+	for i := 0; i < max; i++ {
+		h, ret := d.handle.GetMigDeviceHandleByIndex(i)
+		if ret == nvml.ERROR_NOT_FOUND || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if err := checkRet(ret); err != nil {
+			return nil, err
+		}
+
+		mig, err := buildDeviceFromHandle(h)
+		if err != nil {
+			return nil, err
+		}
+		migs = append(migs, mig)
+	}
+
+	return migs, nil
+}
+
+func (d *device) GetAttributes() (nvml.DeviceAttributes, error) {
+	attr, ret := d.handle.GetAttributes()
+	if err := checkRet(ret); err != nil {
+		return nvml.DeviceAttributes{}, err
+	}
+	return attr, nil
+}
+
+// HasActiveComputeInstances reports whether the GPU currently has any
+// compute process running on it, used to avoid tearing down a GPU's MIG
+// geometry out from under a live workload. Checked at the GPU level, since
+// a running process is disruptive to reconfigure around regardless of
+// which MIG instance it happens to be using.
+func (d *device) HasActiveComputeInstances() (bool, error) {
+	procs, ret := d.handle.GetComputeRunningProcesses()
+	if err := checkRet(ret); err != nil {
+		return false, err
+	}
+	return len(procs) > 0, nil
+}
+
+// DestroyAllGpuInstances destroys every compute and GPU instance currently
+// configured on the device. It walks the device's MIG children the same
+// way GetMigDevices()/CurrentGeometry do, so every profile size is covered
+// rather than just the default (profile ID 0) one.
+func (d *device) DestroyAllGpuInstances() error {
+	migs, err := d.GetMigDevices()
+	if err != nil {
+		return err
+	}
+
+	gpuInstanceIDs := make(map[uint]struct{})
+	for _, m := range migs {
+		if m.GpuInstanceID == nil || m.ComputeInstanceID == nil {
+			continue
+		}
+
+		gi, ret := d.handle.GetGpuInstanceById(int(*m.GpuInstanceID))
+		if err := checkRet(ret); err != nil {
+			return err
+		}
+
+		ci, ret := gi.GetComputeInstanceById(int(*m.ComputeInstanceID))
+		if err := checkRet(ret); err != nil {
+			return err
+		}
+		if err := checkRet(ci.Destroy()); err != nil {
+			return err
+		}
+
+		gpuInstanceIDs[*m.GpuInstanceID] = struct{}{}
+	}
+
+	for id := range gpuInstanceIDs {
+		gi, ret := d.handle.GetGpuInstanceById(int(id))
+		if err := checkRet(ret); err != nil {
+			return err
+		}
+		if err := checkRet(gi.Destroy()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateGpuInstanceForProfile creates a single GPU instance, and its
+// default compute instance, for the named MIG profile (e.g. "1g.5gb").
+//
+// NVML has no name->ID lookup for GPU-instance profiles; it only exposes
+// them as small integer IDs. So, the same way getMigDeviceResourceName
+// derives a resource name from GetAttributes() for an existing MIG device,
+// this walks every profile ID, computes its "<g>g.<gb>gb" name from
+// GetGpuInstanceProfileInfo(), and creates the one that matches.
+func (d *device) CreateGpuInstanceForProfile(profile string) error {
+	info, err := findGpuInstanceProfileInfo(d.handle, profile)
+	if err != nil {
+		return err
+	}
+
+	gi, ret := d.handle.CreateGpuInstance(&info)
+	if err := checkRet(ret); err != nil {
+		return err
+	}
+
+	ciProfileID, ret := gi.GetDefaultComputeInstanceProfileId()
+	if err := checkRet(ret); err != nil {
+		return err
+	}
+
+	ciInfo, ret := gi.GetComputeInstanceProfileInfo(ciProfileID, nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+	if err := checkRet(ret); err != nil {
+		return err
+	}
+
+	_, ret = gi.CreateComputeInstance(&ciInfo)
+	return checkRet(ret)
+}
+
+// findGpuInstanceProfileInfo searches every GPU-instance profile ID for the
+// one whose "<g>g.<gb>gb" name matches profile.
+func findGpuInstanceProfileInfo(h nvml.Device, profile string) (nvml.GpuInstanceProfileInfo, error) {
+	for id := 0; id < nvml.GPU_INSTANCE_PROFILE_COUNT; id++ {
+		info, ret := h.GetGpuInstanceProfileInfo(id)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if err := checkRet(ret); err != nil {
+			return nvml.GpuInstanceProfileInfo{}, err
+		}
+
+		if gpuInstanceProfileName(info) == profile {
+			return info, nil
+		}
+	}
+
+	return nvml.GpuInstanceProfileInfo{}, fmt.Errorf("unknown MIG profile %q", profile)
+}
+
+// gpuInstanceProfileName builds the "<g>g.<gb>gb" name NVML's MIG tooling
+// uses for a GPU-instance profile, e.g. "1g.5gb".
+func gpuInstanceProfileName(info nvml.GpuInstanceProfileInfo) string {
+	gb := (info.MemorySizeMB + 1000 - 1) / 1000
+	return fmt.Sprintf("%dg.%dgb", info.SliceCount, gb)
+}
+
+// DeviceSample is a point-in-time reading of a device's utilization,
+// memory, power and temperature, used by the metrics exporter.
+type DeviceSample struct {
+	MemoryUsedBytes uint64
+	MemoryFreeBytes uint64
+	SMUtilPercent   uint32
+	PowerMilliWatts uint32
+	TemperatureC    uint32
+}
+
+// Sample reads the current utilization/memory/power/temperature for the
+// device. Fields NVML doesn't support for this handle (e.g. utilization on
+// a MIG child) are left at zero rather than failing the whole sample.
+func (d *device) Sample() DeviceSample {
+	var s DeviceSample
+
+	if mem, ret := d.handle.GetMemoryInfo(); ret == nvml.SUCCESS {
+		s.MemoryUsedBytes = mem.Used
+		s.MemoryFreeBytes = mem.Free
+	}
+
+	if util, ret := d.handle.GetUtilizationRates(); ret == nvml.SUCCESS {
+		s.SMUtilPercent = util.Gpu
+	}
+
+	if power, ret := d.handle.GetPowerUsage(); ret == nvml.SUCCESS {
+		s.PowerMilliWatts = power
+	}
+
+	if temp, ret := d.handle.GetTemperature(nvml.TEMPERATURE_GPU); ret == nvml.SUCCESS {
+		s.TemperatureC = temp
+	}
+
+	return s
+}
+
+// MemoryMB returns the device's total memory, in MB, rounding up.
+func (d *device) MemoryMB() (uint64, error) {
+	mem, ret := d.handle.GetMemoryInfo()
+	if err := checkRet(ret); err != nil {
+		return 0, err
+	}
+	return (mem.Total + (1024 * 1024) - 1) / (1024 * 1024), nil
+}
+
+// ComputeCapability returns the device's CUDA compute capability as
+// "<major>.<minor>", e.g. "8.0".
+func (d *device) ComputeCapability() (string, error) {
+	major, minor, ret := d.handle.GetCudaComputeCapability()
+	if err := checkRet(ret); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d", major, minor), nil
+}
+
+// MigProfile returns the "mig-<c>c.<g>g.<gb>gb" resource name for a MIG
+// device, or "" for a full GPU. It defers to getMigDeviceResourceName (the
+// same helper migStrategySingle/migStrategyMixed use) so there is only one
+// place that derives this string from a device's attributes.
+func (d *device) MigProfile() (string, error) {
+	if d.GpuInstanceID == nil {
+		return "", nil
+	}
+	return getMigDeviceResourceName(d), nil
+}
+
+// pciBusIDToString converts the NUL-terminated char array NVML returns for
+// a PCI BusID into a Go string.
+func pciBusIDToString(busID [32]int8) string {
+	b := make([]byte, 0, len(busID))
+	for _, c := range busID {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// --- event handling shim -------------------------------------------------
+//
+// go-nvml exposes the same EventSet/RegisterEventForDevice/WaitForEvent
+// primitives as the old bindings, but keyed off nvml.Return rather than
+// error, and with Xid data delivered as a raw EventData struct. The helpers
+// below translate between the two so checkHealth can stay as-is.
+
+const nvmlXidCriticalError = nvml.EventTypeXidCriticalError
+
+type nvmlEvent struct {
+	Etype             uint64
+	Edata             uint64
+	UUID              *string
+	GpuInstanceId     *uint
+	ComputeInstanceId *uint
+}
+
+func nvmlNewEventSet() nvml.EventSet {
+	set, ret := nvml.EventSetCreate()
+	if err := checkRet(ret); err != nil {
+		log.Panicln("Fatal:", err)
+	}
+	return set
+}
+
+func nvmlDeleteEventSet(set nvml.EventSet) {
+	set.Free()
+}
+
+func nvmlRegisterEventForDevice(set nvml.EventSet, eventType uint64, uuid string) error {
+	h, ret := nvml.DeviceGetHandleByUUID(uuid)
+	if err := checkRet(ret); err != nil {
+		return err
+	}
+	return checkRet(h.RegisterEvents(eventType, set))
+}
+
+func nvmlWaitForEvent(set nvml.EventSet, timeoutms uint) (nvmlEvent, error) {
+	data, ret := set.Wait(uint32(timeoutms))
+	if ret == nvml.ERROR_TIMEOUT {
+		return nvmlEvent{}, errors.New(nvml.ErrorString(ret))
+	}
+	if err := checkRet(ret); err != nil {
+		return nvmlEvent{}, err
+	}
+
+	e := nvmlEvent{
+		Etype: data.EventType,
+		Edata: data.EventData,
+	}
+
+	if uuid, ret := data.Device.GetUUID(); ret == nvml.SUCCESS {
+		e.UUID = &uuid
+	}
+	// Always set, as the old bindings did: NVML reports 0xFFFFFFFF for
+	// events that are not tied to a MIG instance.
+	gi := uint(data.GpuInstanceId)
+	ci := uint(data.ComputeInstanceId)
+	e.GpuInstanceId = &gi
+	e.ComputeInstanceId = &ci
+
+	return e, nil
+}
+
+// nvmlParseMigDeviceUUID preserves the semantics of the deprecated
+// bindings' ParseMigDeviceUUID: a MIG device UUID looks like
+// "MIG-<gpu-uuid>/<gi>/<ci>"; anything else is treated as a full GPU UUID
+// and returns an error so callers fall back to comparing it directly.
+func nvmlParseMigDeviceUUID(uuid string) (string, uint, uint, error) {
+	tokens := strings.SplitN(uuid, "-", 2)
+	if len(tokens) != 2 || tokens[0] != "MIG" {
+		return "", 0, 0, fmt.Errorf("unable to parse UUID as MIG device: %v", uuid)
+	}
+
+	tokens = strings.SplitN(tokens[1], "/", 3)
+	if len(tokens) != 3 {
+		return "", 0, 0, fmt.Errorf("unable to parse UUID as MIG device: %v", uuid)
+	}
+
+	var gi, ci uint
+	if _, err := fmt.Sscanf(tokens[1], "%d", &gi); err != nil {
+		return "", 0, 0, fmt.Errorf("unable to parse GPU instance ID: %v", err)
+	}
+	if _, err := fmt.Sscanf(tokens[2], "%d", &ci); err != nil {
+		return "", 0, 0, fmt.Errorf("unable to parse Compute instance ID: %v", err)
+	}
+
+	return tokens[0], gi, ci, nil
+}